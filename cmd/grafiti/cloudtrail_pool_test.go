@@ -0,0 +1,166 @@
+// Copyright © 2017 grafiti/predator authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+	"github.com/spf13/viper"
+)
+
+// testContext sets an hour range so parseFromCloudTrail doesn't bail out
+// before building its LookupAttributes, and returns a background context.
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+	viper.Set("startHour", -1)
+	viper.Set("endHour", 1)
+	t.Cleanup(func() {
+		viper.Set("startHour", nil)
+		viper.Set("endHour", nil)
+	})
+	return context.Background()
+}
+
+// poolCloudTrail is a fake cloudtrailiface.CloudTrailAPI that returns two
+// pages of one event per LookupAttribute, tagging each resource name with
+// its attribute and page index so tests can check processing and ordering.
+// Lookups for failAttr return an error on first call.
+type poolCloudTrail struct {
+	cloudtrailiface.CloudTrailAPI
+
+	failAttr string
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *poolCloudTrail) LookupEventsWithContext(ctx aws.Context, in *cloudtrail.LookupEventsInput, opts ...request.Option) (*cloudtrail.LookupEventsOutput, error) {
+	attr := ""
+	if len(in.LookupAttributes) > 0 && in.LookupAttributes[0] != nil {
+		attr = aws.StringValue(in.LookupAttributes[0].AttributeValue)
+	}
+
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = map[string]int{}
+	}
+	f.calls[attr]++
+	page := f.calls[attr]
+	f.mu.Unlock()
+
+	if attr == f.failAttr {
+		return nil, fmt.Errorf("simulated LookupEvents failure for %s", attr)
+	}
+
+	name := fmt.Sprintf("%s-resource-%d", attr, page)
+	raw := fmt.Sprintf(`{"eventName":"CreateBucket","requestParameters":{"bucketName":%q}}`, name)
+	event := &cloudtrail.Event{
+		CloudTrailEvent: aws.String(raw),
+		Resources: []*cloudtrail.Resource{
+			{ResourceName: aws.String(name), ResourceType: aws.String("AWS::S3::Bucket")},
+		},
+	}
+
+	out := &cloudtrail.LookupEventsOutput{Events: []*cloudtrail.Event{event}}
+	if page == 1 {
+		out.NextToken = aws.String("page-2")
+	}
+	return out, nil
+}
+
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %s", err)
+	}
+
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+
+	fn()
+
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading captured stdout: %s", err)
+	}
+	return string(out)
+}
+
+func setResourceTypesForPoolTest(t *testing.T, rts []string) {
+	t.Helper()
+	viper.Set("resourceTypes", rts)
+	viper.Set("lookupConcurrency", len(rts))
+	t.Cleanup(func() {
+		viper.Set("resourceTypes", nil)
+		viper.Set("lookupConcurrency", nil)
+	})
+}
+
+func TestParseFromCloudTrailProcessesAllAttributesInStableOrder(t *testing.T) {
+	rts := []string{"AWS::S3::Bucket", "AWS::EC2::Instance", "AWS::EC2::Vpc"}
+	setResourceTypesForPoolTest(t, rts)
+
+	fake := &poolCloudTrail{}
+
+	ctx := testContext(t)
+
+	output := captureStdout(t, func() {
+		if err := parseFromCloudTrail(ctx, fake); err != nil {
+			t.Fatalf("parseFromCloudTrail: %s", err)
+		}
+	})
+
+	for _, rt := range rts {
+		firstIdx := strings.Index(output, rt+"-resource-1")
+		secondIdx := strings.Index(output, rt+"-resource-2")
+		if firstIdx == -1 || secondIdx == -1 {
+			t.Fatalf("missing output for attribute %s: %q", rt, output)
+		}
+		if firstIdx > secondIdx {
+			t.Errorf("attribute %s: page 1 output (%d) came after page 2 (%d); ordering not stable per-attribute", rt, firstIdx, secondIdx)
+		}
+	}
+}
+
+func TestParseFromCloudTrailReturnsWorkerError(t *testing.T) {
+	rts := []string{"AWS::S3::Bucket", "AWS::EC2::Instance", "AWS::EC2::Vpc"}
+	setResourceTypesForPoolTest(t, rts)
+
+	fake := &poolCloudTrail{failAttr: "AWS::EC2::Instance"}
+
+	ctx := testContext(t)
+
+	err := parseFromCloudTrail(ctx, fake)
+	if err == nil {
+		t.Fatal("parseFromCloudTrail: expected error from failing worker, got nil")
+	}
+	if !strings.Contains(err.Error(), "simulated LookupEvents failure") {
+		t.Errorf("parseFromCloudTrail error = %q, want it to mention the simulated failure", err.Error())
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright © 2017 grafiti/predator authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// blockingCloudTrail is a fake cloudtrailiface.CloudTrailAPI whose
+// LookupEventsWithContext never returns on its own; it only unblocks when the
+// context passed to it is canceled, simulating a hung region or API call.
+type blockingCloudTrail struct {
+	cloudtrailiface.CloudTrailAPI
+}
+
+func (b *blockingCloudTrail) LookupEventsWithContext(ctx aws.Context, in *cloudtrail.LookupEventsInput, opts ...request.Option) (*cloudtrail.LookupEventsOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestParseLookupAttributeRespectsLookupTimeout(t *testing.T) {
+	viper.Set("lookupTimeout", "20ms")
+	defer viper.Set("lookupTimeout", nil)
+
+	start := aws.Time(time.Now().Add(-time.Hour))
+	end := aws.Time(time.Now())
+
+	done := make(chan struct{})
+	var lines []string
+	var err error
+
+	go func() {
+		lines, err = parseLookupAttribute(context.Background(), &blockingCloudTrail{}, rate.NewLimiter(rate.Inf, 1), nil, start, end)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("parseLookupAttribute did not return after lookupTimeout elapsed; LookupEventsWithContext call was not canceled")
+	}
+
+	if err != nil {
+		t.Errorf("parseLookupAttribute returned error %v, want nil (timeout is reported, not returned, as a parse-level error)", err)
+	}
+	if len(lines) != 0 {
+		t.Errorf("parseLookupAttribute returned lines %v, want none", lines)
+	}
+}
+
+func TestParseLookupAttributeStopsOnParentCancel(t *testing.T) {
+	viper.Set("lookupTimeout", nil)
+
+	start := aws.Time(time.Now().Add(-time.Hour))
+	end := aws.Time(time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		parseLookupAttribute(ctx, &blockingCloudTrail{}, rate.NewLimiter(rate.Inf, 1), nil, start, end)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("parseLookupAttribute did not return after parent context was canceled")
+	}
+}
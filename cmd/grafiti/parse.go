@@ -15,49 +15,177 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudtrail"
 	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	jq "github.com/threatgrid/jqpipe-go"
 	"github.com/tidwall/gjson"
+	"golang.org/x/time/rate"
 
 	"github.com/coreos/grafiti/arn"
 )
 
-var inputFile string
+var (
+	inputFile string
+	s3Bucket  string
+	s3Prefix  string
+)
 
-// Holds data that identifies a raw CloudTrail event: gjson.Result search path
-// for resource name, and resource type
-type rawEventIdentity struct {
+// EventDescriptor holds data that identifies the resource(s) created by a raw
+// CloudTrail event: the resource type, and a gjson.Result search path for the
+// resource name(s). ResourceNamePath may resolve to a single value or, for
+// events that create more than one resource (e.g. RunInstances), to an array.
+//
+// ARNTemplate and IDPaths are an ARN-construction fallback for services
+// arn.MapResourceTypeToARN doesn't (yet) know how to map: ARNTemplate is a
+// fmt.Sprintf format string with one %s verb per entry in IDPaths, each
+// resolved from the raw event via gjson. They're used only when
+// MapResourceTypeToARN returns "" for ResourceType, so operators can register
+// a brand-new service end-to-end via the rawEventMappings config field
+// without recompiling grafiti or touching the arn package.
+//
+// This type lives in package main rather than as arn.EventDescriptor because
+// the arn package isn't part of this change; relocating it is a mechanical
+// follow-up if/when arn takes on registry responsibilities.
+type EventDescriptor struct {
 	ResourceType     string
 	ResourceNamePath string
+	ARNTemplate      string
+	IDPaths          []string
 }
 
-// Maps CloudTrail eventName to a rawEventIdentity
-var rawEventMap = map[string]rawEventIdentity{
-	"RunInstances":           {arn.EC2InstanceRType, "responseElements.instancesSet.items.0.instanceId"},
-	"CreateBucket":           {arn.S3BucketRType, "requestParameters.bucketName"},
-	"CreateAutoScalingGroup": {arn.AutoScalingGroupRType, "requestParameters.autoScalingGroupName"},
-	"CreateVpc":              {arn.EC2VPCRType, "responseElements.vpc.vpcId"},
-	"CreateSubnet":           {arn.EC2SubnetRType, "responseElements.subnet.subnetId"},
-	"CreateLoadBalancer":     {arn.ElasticLoadBalancingLoadBalancerRType, "requestParameters.loadBalancerName"},
-	"CreateInternetGateway":  {arn.EC2InternetGatewayRType, "responseElements.internetGateway.internetGatewayId"},
-	"CreateSecurityGroup":    {arn.EC2SecurityGroupRType, "responseElements.groupId"},
-	"CreateNetworkInterface": {arn.EC2NetworkInterfaceRType, "responseElements.networkInterface.networkInterfaceId"},
+// rawEventMap maps a CloudTrail eventName to the EventDescriptor describing
+// the resource(s) it creates. It is seeded with a descriptor for every
+// resource type arn.MapResourceTypeToARN handles, and can be extended at
+// runtime with Register or overridden via the rawEventMappings config field.
+var rawEventMap = map[string]EventDescriptor{}
+
+// Register adds or overrides the EventDescriptor for a CloudTrail eventName.
+func Register(eventName string, d EventDescriptor) {
+	rawEventMap[eventName] = d
+}
+
+func init() {
+	// EC2
+	Register("RunInstances", EventDescriptor{ResourceType: arn.EC2InstanceRType, ResourceNamePath: "responseElements.instancesSet.items.#.instanceId"})
+	Register("CreateVpc", EventDescriptor{ResourceType: arn.EC2VPCRType, ResourceNamePath: "responseElements.vpc.vpcId"})
+	Register("CreateSubnet", EventDescriptor{ResourceType: arn.EC2SubnetRType, ResourceNamePath: "responseElements.subnet.subnetId"})
+	Register("CreateInternetGateway", EventDescriptor{ResourceType: arn.EC2InternetGatewayRType, ResourceNamePath: "responseElements.internetGateway.internetGatewayId"})
+	Register("CreateSecurityGroup", EventDescriptor{ResourceType: arn.EC2SecurityGroupRType, ResourceNamePath: "responseElements.groupId"})
+	Register("CreateNetworkInterface", EventDescriptor{ResourceType: arn.EC2NetworkInterfaceRType, ResourceNamePath: "responseElements.networkInterface.networkInterfaceId"})
+	Register("CreateVolume", EventDescriptor{ResourceType: arn.EC2VolumeRType, ResourceNamePath: "responseElements.volumeId"})
+	Register("CreateSnapshot", EventDescriptor{ResourceType: arn.EC2SnapshotRType, ResourceNamePath: "responseElements.snapshotId"})
+	Register("CreateImage", EventDescriptor{ResourceType: arn.EC2AMIRType, ResourceNamePath: "responseElements.imageId"})
+	Register("CreateKeyPair", EventDescriptor{ResourceType: arn.EC2KeyPairRType, ResourceNamePath: "responseElements.keyName"})
+	Register("AllocateAddress", EventDescriptor{ResourceType: arn.EC2EIPRType, ResourceNamePath: "responseElements.allocationId"})
+	Register("CreateRouteTable", EventDescriptor{ResourceType: arn.EC2RouteTableRType, ResourceNamePath: "responseElements.routeTable.routeTableId"})
+	Register("CreateNetworkAcl", EventDescriptor{ResourceType: arn.EC2NetworkAclRType, ResourceNamePath: "responseElements.networkAcl.networkAclId"})
+	Register("CreateCustomerGateway", EventDescriptor{ResourceType: arn.EC2CustomerGatewayRType, ResourceNamePath: "responseElements.customerGateway.customerGatewayId"})
+	Register("CreateVpnGateway", EventDescriptor{ResourceType: arn.EC2VPNGatewayRType, ResourceNamePath: "responseElements.vpnGateway.vpnGatewayId"})
+	Register("CreateVpnConnection", EventDescriptor{ResourceType: arn.EC2VPNConnectionRType, ResourceNamePath: "responseElements.vpnConnection.vpnConnectionId"})
+	Register("CreateVpcPeeringConnection", EventDescriptor{ResourceType: arn.EC2VPCPeeringConnectionRType, ResourceNamePath: "responseElements.vpcPeeringConnection.vpcPeeringConnectionId"})
+	Register("CreateNatGateway", EventDescriptor{ResourceType: arn.EC2NatGatewayRType, ResourceNamePath: "responseElements.natGateway.natGatewayId"})
+	Register("CreateVpcEndpoint", EventDescriptor{ResourceType: arn.EC2VPCEndpointRType, ResourceNamePath: "responseElements.vpcEndpoint.vpcEndpointId"})
+
+	// S3
+	Register("CreateBucket", EventDescriptor{ResourceType: arn.S3BucketRType, ResourceNamePath: "requestParameters.bucketName"})
+
+	// Auto Scaling
+	Register("CreateAutoScalingGroup", EventDescriptor{ResourceType: arn.AutoScalingGroupRType, ResourceNamePath: "requestParameters.autoScalingGroupName"})
+	Register("CreateLaunchConfiguration", EventDescriptor{ResourceType: arn.AutoScalingLaunchConfigurationRType, ResourceNamePath: "requestParameters.launchConfigurationName"})
+
+	// Elastic Load Balancing
+	Register("CreateLoadBalancer", EventDescriptor{ResourceType: arn.ElasticLoadBalancingLoadBalancerRType, ResourceNamePath: "requestParameters.loadBalancerName"})
+	Register("CreateTargetGroup", EventDescriptor{ResourceType: arn.ElasticLoadBalancingV2TargetGroupRType, ResourceNamePath: "requestParameters.name"})
+
+	// IAM
+	Register("CreateRole", EventDescriptor{ResourceType: arn.IAMRoleRType, ResourceNamePath: "requestParameters.roleName"})
+	Register("CreateUser", EventDescriptor{ResourceType: arn.IAMUserRType, ResourceNamePath: "requestParameters.userName"})
+	Register("CreateGroup", EventDescriptor{ResourceType: arn.IAMGroupRType, ResourceNamePath: "requestParameters.groupName"})
+	Register("CreatePolicy", EventDescriptor{ResourceType: arn.IAMPolicyRType, ResourceNamePath: "responseElements.policy.policyName"})
+	Register("CreateInstanceProfile", EventDescriptor{ResourceType: arn.IAMInstanceProfileRType, ResourceNamePath: "requestParameters.instanceProfileName"})
+
+	// RDS
+	Register("CreateDBInstance", EventDescriptor{ResourceType: arn.RDSDBInstanceRType, ResourceNamePath: "requestParameters.dBInstanceIdentifier"})
+	Register("CreateDBSubnetGroup", EventDescriptor{ResourceType: arn.RDSDBSubnetGroupRType, ResourceNamePath: "requestParameters.dBSubnetGroupName"})
+	Register("CreateDBSnapshot", EventDescriptor{ResourceType: arn.RDSDBSnapshotRType, ResourceNamePath: "requestParameters.dBSnapshotIdentifier"})
+
+	// Route53
+	Register("CreateHostedZone", EventDescriptor{ResourceType: arn.Route53HostedZoneRType, ResourceNamePath: "responseElements.hostedZone.id"})
+
+	// CloudFormation
+	Register("CreateStack", EventDescriptor{ResourceType: arn.CloudFormationStackRType, ResourceNamePath: "requestParameters.stackName"})
+
+	// SQS / SNS
+	Register("CreateQueue", EventDescriptor{ResourceType: arn.SQSQueueRType, ResourceNamePath: "requestParameters.queueName"})
+	Register("CreateTopic", EventDescriptor{ResourceType: arn.SNSTopicRType, ResourceNamePath: "requestParameters.name"})
+
+	// DynamoDB
+	Register("CreateTable", EventDescriptor{ResourceType: arn.DynamoDBTableRType, ResourceNamePath: "requestParameters.tableName"})
+
+	// ElastiCache
+	Register("CreateCacheCluster", EventDescriptor{ResourceType: arn.ElastiCacheClusterRType, ResourceNamePath: "requestParameters.cacheClusterId"})
+}
+
+// rawEventMapping is the TOML shape of one entry in the rawEventMappings
+// config field, used to register additional or overriding EventDescriptors
+// without recompiling grafiti.
+type rawEventMapping struct {
+	EventName        string   `mapstructure:"eventName"`
+	ResourceType     string   `mapstructure:"resourceType"`
+	ResourceNamePath string   `mapstructure:"resourceNamePath"`
+	ARNTemplate      string   `mapstructure:"arnTemplate"`
+	IDPaths          []string `mapstructure:"idPaths"`
+}
+
+// loadRawEventMappings registers every entry of the rawEventMappings config
+// field, letting operators add new AWS services to rawEventMap without
+// recompiling grafiti.
+func loadRawEventMappings() error {
+	var mappings []rawEventMapping
+	if err := viper.UnmarshalKey("rawEventMappings", &mappings); err != nil {
+		return err
+	}
+
+	for _, m := range mappings {
+		if m.EventName == "" {
+			continue
+		}
+		Register(m.EventName, EventDescriptor{
+			ResourceType:     m.ResourceType,
+			ResourceNamePath: m.ResourceNamePath,
+			ARNTemplate:      m.ARNTemplate,
+			IDPaths:          m.IDPaths,
+		})
+	}
+
+	return nil
 }
 
 func init() {
 	RootCmd.AddCommand(parseCmd)
 	parseCmd.PersistentFlags().StringVarP(&inputFile, "input-file", "f", "", "CloudTrail log file of raw CloudTrail events.")
+	parseCmd.PersistentFlags().StringVar(&s3Bucket, "s3-bucket", "", "S3 bucket holding a CloudTrail log archive.")
+	parseCmd.PersistentFlags().StringVar(&s3Prefix, "s3-prefix", "", "Prefix of the CloudTrail log archive within the S3 bucket, e.g. AWSLogs/123456789012/CloudTrail/us-east-1.")
 }
 
 var parseCmd = &cobra.Command{
@@ -68,22 +196,99 @@ var parseCmd = &cobra.Command{
 }
 
 func runParseCommand(cmd *cobra.Command, args []string) error {
+	if err := loadRawEventMappings(); err != nil {
+		return err
+	}
+
+	if s3Bucket != "" {
+		return parseFromS3(s3Bucket, s3Prefix)
+	}
+
 	if inputFile != "" {
 		return parseFromFile(inputFile)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if overallTimeout := viper.GetDuration("overallTimeout"); overallTimeout > 0 {
+		overall := newDeadlineTimer(overallTimeout)
+		defer overall.stop()
+		go func() {
+			select {
+			case <-overall.fired:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
 	sess := session.Must(session.NewSession(
 		&aws.Config{
 			Region: aws.String(viper.GetString("region")),
 		},
 	))
-	if err := parseFromCloudTrail(cloudtrail.New(sess)); err != nil {
+	if err := parseFromCloudTrail(ctx, cloudtrail.New(sess)); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// deadlineTimer fires its fired channel once, after the configured duration
+// elapses without being reset. setDeadline is called after each successful
+// unit of work to push the deadline back out. fired is closed at most once
+// even though Reset can re-arm the underlying timer after it has already
+// fired.
+type deadlineTimer struct {
+	timer *time.Timer
+	fired chan struct{}
+	once  sync.Once
+}
+
+func newDeadlineTimer(d time.Duration) *deadlineTimer {
+	dt := &deadlineTimer{fired: make(chan struct{})}
+	dt.timer = time.AfterFunc(d, func() { dt.once.Do(func() { close(dt.fired) }) })
+	return dt
+}
+
+func (dt *deadlineTimer) setDeadline(d time.Duration) {
+	dt.timer.Reset(d)
+}
+
+func (dt *deadlineTimer) stop() {
+	dt.timer.Stop()
+}
+
+// withLookupDeadline derives a context from parent that is canceled either
+// when parent is, or when timeout elapses without a call to the returned
+// deadlineTimer's setDeadline. If timeout is non-positive, the deadline is
+// disabled and parent is returned unchanged. The returned CancelFunc stops
+// the deadline timer in addition to canceling the context.
+func withLookupDeadline(parent context.Context, timeout time.Duration) (context.Context, *deadlineTimer, context.CancelFunc) {
+	if timeout <= 0 {
+		return parent, nil, func() {}
+	}
+
+	dt := newDeadlineTimer(timeout)
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-dt.fired:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, dt, func() {
+		dt.stop()
+		cancel()
+	}
+}
+
 // CloudTrailLogFile holds the array of Record strings in a S3 CloudTrail log
 // archive.
 type CloudTrailLogFile struct {
@@ -109,24 +314,249 @@ func parseFromFile(logFileName string) error {
 			continue
 		}
 
-		fmt.Println(parseRawCloudTrailEvent(string(event)))
+		for _, line := range parseRawCloudTrailEvent(string(event)) {
+			if line != "" {
+				fmt.Println(line)
+			}
+		}
+	}
+
+	return nil
+}
+
+// s3KeyTimeRegexp pulls the YYYY/MM/DD date folders and HHMM timestamp out of
+// a standard CloudTrail S3 archive key, e.g.
+// AWSLogs/123456789012/CloudTrail/us-east-1/2018/01/02/123456789012_CloudTrail_us-east-1_20180102T0305Z_abcdefgh.json.gz
+var s3KeyTimeRegexp = regexp.MustCompile(`/(\d{4})/(\d{2})/(\d{2})/[^/]+_(\d{8}T\d{4})Z_`)
+
+// timeFromS3Key returns the timestamp embedded in a CloudTrail S3 archive key
+// and whether the key matched the expected layout.
+func timeFromS3Key(key string) (time.Time, bool) {
+	m := s3KeyTimeRegexp.FindStringSubmatch(key)
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse("20060102T1504", m[4])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t.UTC(), true
+}
+
+// s3StartAfterKey builds a ListObjectsV2 StartAfter value that skips archives
+// in UTC date folders before start's UTC day, so the server-side listing
+// doesn't walk the whole bucket.
+func s3StartAfterKey(prefix string, start time.Time) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	start = start.UTC()
+	return fmt.Sprintf("%s/%04d/%02d/%02d", prefix, start.Year(), start.Month(), start.Day())
+}
+
+// parseFromS3 streams CloudTrail events out of an S3 archive bucket instead
+// of a local log file, filtering archives to the window in the s3Interval
+// config field.
+func parseFromS3(bucket, prefix string) error {
+	interval := viper.GetString("s3Interval")
+	if interval == "" {
+		return fmt.Errorf("s3Interval must be set to a duration, timestamp, or <start>,<end> range when using --s3-bucket")
+	}
+
+	start, end := calcTimeWindowFromS3Interval(interval)
+	if start == nil || end == nil {
+		return fmt.Errorf("invalid s3Interval %q", interval)
+	}
+
+	sess := session.Must(session.NewSession(
+		&aws.Config{
+			Region: aws.String(viper.GetString("region")),
+		},
+	))
+
+	return parseFromS3Bucket(s3.New(sess), bucket, prefix, start, end)
+}
+
+// calcTimeWindowFromS3Interval parses the s3Interval config field, which may
+// be a single relative duration ("24h", "7d"), a single RFC3339 timestamp, or
+// a "<start>,<end>" range of either form. A single-sided value means "that
+// far in the past, or that instant, until now".
+func calcTimeWindowFromS3Interval(interval string) (*time.Time, *time.Time) {
+	if interval == "" {
+		return nil, nil
+	}
+
+	now := time.Now()
+	parts := strings.SplitN(interval, ",", 2)
+
+	start, err := parseS3IntervalEndpoint(parts[0], now)
+	if err != nil {
+		fmt.Printf("{\"error\": \"s3Interval parse error: %s\"}\n", err.Error())
+		return nil, nil
+	}
+
+	end := now
+	if len(parts) == 2 {
+		end, err = parseS3IntervalEndpoint(parts[1], now)
+		if err != nil {
+			fmt.Printf("{\"error\": \"s3Interval parse error: %s\"}\n", err.Error())
+			return nil, nil
+		}
+	}
+
+	if start.After(end) || start.Equal(end) {
+		fmt.Printf(`{"error": "s3Interval start (%s) is at or after end (%s)"}%s`, start, end, "\n")
+		return nil, nil
+	}
+
+	return aws.Time(start), aws.Time(end)
+}
+
+// parseS3IntervalEndpoint parses one side of an s3Interval value: an RFC3339
+// timestamp, or a duration ("24h", "7d") relative to now.
+func parseS3IntervalEndpoint(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	if strings.HasSuffix(s, "d") {
+		days, err := time.ParseDuration(strings.TrimSuffix(s, "d") + "h")
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid s3Interval value %q: %s", s, err.Error())
+		}
+		return now.Add(-days * 24), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid s3Interval value %q: %s", s, err.Error())
+	}
+
+	return now.Add(-d), nil
+}
+
+// parseFromS3Bucket lists the objects under prefix, skips any archive dated
+// outside [start, end], and parses the rest.
+func parseFromS3Bucket(svc s3iface.S3API, bucket, prefix string, start, end *time.Time) error {
+	params := &s3.ListObjectsV2Input{
+		Bucket:     aws.String(bucket),
+		Prefix:     aws.String(prefix),
+		StartAfter: aws.String(s3StartAfterKey(prefix, *start)),
+	}
+
+	var pageErr error
+	err := svc.ListObjectsV2Pages(params, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+
+			t, ok := timeFromS3Key(key)
+			if !ok || t.Before(*start) || t.After(*end) {
+				continue
+			}
+
+			if pageErr = parseS3Object(svc, bucket, key); pageErr != nil {
+				return false
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return pageErr
+}
+
+// parseS3Object downloads, gunzips, and parses a single CloudTrail log
+// archive object.
+func parseS3Object(svc s3iface.S3API, bucket, key string) error {
+	resp, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	gzr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	raw, err := ioutil.ReadAll(gzr)
+	if err != nil {
+		return err
+	}
+
+	var logFile CloudTrailLogFile
+	if err = json.Unmarshal(raw, &logFile); err != nil {
+		return err
+	}
+
+	for _, eventData := range logFile.Events {
+		event, err := eventData.MarshalJSON()
+		if err != nil {
+			continue
+		}
+
+		for _, line := range parseRawCloudTrailEvent(string(event)) {
+			if line != "" {
+				fmt.Println(line)
+			}
+		}
 	}
 
 	return nil
 }
 
-func parseRawCloudTrailEvent(event string) string {
+// parseRawCloudTrailEvent returns one output line per resource the event
+// created, per the ResourceNamePath registered for its eventName. It returns
+// nil if the eventName isn't registered.
+func parseRawCloudTrailEvent(event string) []string {
 	parsedEvent := gjson.Parse(event)
 	eventName := parsedEvent.Get("eventName")
-	eventIdentity, ok := rawEventMap[eventName.String()]
+	eventDescriptor, ok := rawEventMap[eventName.String()]
 	if !ok {
+		return nil
+	}
+
+	rt := arn.ResourceType(eventDescriptor.ResourceType)
+	names := parsedEvent.Get(eventDescriptor.ResourceNamePath)
+	fallback := fallbackARN(eventDescriptor, parsedEvent)
+
+	if !names.IsArray() {
+		return []string{parseDataFromEvent(rt, arn.ResourceName(names.String()), parsedEvent, nil, fallback)}
+	}
+
+	lines := make([]string, 0, len(names.Array()))
+	for _, n := range names.Array() {
+		lines = append(lines, parseDataFromEvent(rt, arn.ResourceName(n.String()), parsedEvent, nil, fallback))
+	}
+
+	return lines
+}
+
+// fallbackARN builds an ARN from an EventDescriptor's ARNTemplate by
+// resolving each of its IDPaths out of the raw event via gjson and
+// formatting them into the template in order. It returns "" if ARNTemplate
+// is unset, so descriptors that rely solely on arn.MapResourceTypeToARN are
+// unaffected. This lets the rawEventMappings config register a service
+// arn.MapResourceTypeToARN doesn't know how to map without recompiling
+// grafiti.
+func fallbackARN(d EventDescriptor, parsedEvent gjson.Result) arn.ResourceARN {
+	if d.ARNTemplate == "" {
 		return ""
 	}
 
-	rn := arn.ResourceName(parsedEvent.Get(eventIdentity.ResourceNamePath).String())
-	rt := arn.ResourceType(eventIdentity.ResourceType)
+	ids := make([]interface{}, len(d.IDPaths))
+	for i, p := range d.IDPaths {
+		ids[i] = parsedEvent.Get(p).String()
+	}
 
-	return parseDataFromEvent(rt, rn, parsedEvent, nil)
+	return arn.ResourceARN(fmt.Sprintf(d.ARNTemplate, ids...))
 }
 
 // NotTaggedFilter holds the resource types of all resources not tagged
@@ -134,7 +564,12 @@ type NotTaggedFilter struct {
 	Type string `json:"type"`
 }
 
-func parseFromCloudTrail(svc cloudtrailiface.CloudTrailAPI) error {
+// parseFromCloudTrail fans the configured resourceTypes out across a bounded
+// pool of worker goroutines, each paginating its own LookupAttribute, while a
+// single writer goroutine prints their output so stdout stays line-atomic
+// under concurrency. Output is stable per-attribute; interleaving across
+// attributes is not guaranteed.
+func parseFromCloudTrail(ctx context.Context, svc cloudtrailiface.CloudTrailAPI) error {
 	var start, end *time.Time
 	// Check if timestamps or hours exist
 	if viper.IsSet("startTimeStamp") && viper.IsSet("endTimeStamp") {
@@ -161,13 +596,80 @@ func parseFromCloudTrail(svc cloudtrailiface.CloudTrailAPI) error {
 		}
 	}
 
-	for _, attr := range attrs {
-		if err := parseLookupAttribute(svc, attr, start, end); err != nil {
-			return err
+	concurrency := viper.GetInt("lookupConcurrency")
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	if concurrency > len(attrs) {
+		concurrency = len(attrs)
+	}
+
+	// CloudTrail enforces a 2 lookups/sec/account throttle on LookupEvents.
+	limiter := rate.NewLimiter(rate.Limit(2), 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	attrCh := make(chan *cloudtrail.LookupAttribute)
+	go func() {
+		defer close(attrCh)
+		for _, attr := range attrs {
+			select {
+			case attrCh <- attr:
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
+
+	linesCh := make(chan []string)
+	errCh := make(chan error, concurrency)
+
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for attr := range attrCh {
+				lines, err := parseLookupAttribute(ctx, svc, limiter, attr, start, end)
+				if err != nil {
+					errCh <- err
+					cancel()
+					return
+				}
+
+				select {
+				case linesCh <- lines:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
 	}
 
-	return nil
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for lines := range linesCh {
+			for _, line := range lines {
+				if line != "" {
+					fmt.Println(line)
+				}
+			}
+		}
+	}()
+
+	workers.Wait()
+	close(linesCh)
+	<-writerDone
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+	}
+
+	return ctx.Err()
 }
 
 // Calculates a time window between a starting RFC3339 timestamp string and
@@ -207,7 +709,14 @@ func calcTimeWindowFromHourRange(start, end int) (*time.Time, *time.Time) {
 	return aws.Time(startTime), aws.Time(endTime)
 }
 
-func parseLookupAttribute(svc cloudtrailiface.CloudTrailAPI, attr *cloudtrail.LookupAttribute, start, end *time.Time) error {
+// parseLookupAttribute paginates a single LookupAttribute and returns its
+// output lines in page order, rate-limited through limiter so a concurrent
+// pool of callers doesn't exceed CloudTrail's account-wide throttle.
+func parseLookupAttribute(ctx context.Context, svc cloudtrailiface.CloudTrailAPI, limiter *rate.Limiter, attr *cloudtrail.LookupAttribute, start, end *time.Time) ([]string, error) {
+	lookupTimeout := viper.GetDuration("lookupTimeout")
+	lookupCtx, dt, cancel := withLookupDeadline(ctx, lookupTimeout)
+	defer cancel()
+
 	params := &cloudtrail.LookupEventsInput{
 		EndTime:          end,
 		MaxResults:       aws.Int64(50),
@@ -215,14 +724,34 @@ func parseLookupAttribute(svc cloudtrailiface.CloudTrailAPI, attr *cloudtrail.Lo
 		LookupAttributes: []*cloudtrail.LookupAttribute{attr},
 	}
 
+	var lines []string
 	for {
-		ctx := aws.BackgroundContext()
-		resp, err := svc.LookupEventsWithContext(ctx, params)
+		if err := ctx.Err(); err != nil {
+			return lines, err
+		}
+
+		if err := limiter.Wait(lookupCtx); err != nil {
+			if lookupCtx.Err() != nil && ctx.Err() == nil {
+				fmt.Printf(`{"error": "lookup timeout after %s"}%s`, lookupTimeout, "\n")
+				return lines, nil
+			}
+			return lines, err
+		}
+
+		resp, err := svc.LookupEventsWithContext(lookupCtx, params)
 		if err != nil {
-			return err
+			if lookupCtx.Err() != nil && ctx.Err() == nil {
+				fmt.Printf(`{"error": "lookup timeout after %s"}%s`, lookupTimeout, "\n")
+				return lines, nil
+			}
+			return lines, err
 		}
 
-		printEvents(resp.Events)
+		if dt != nil {
+			dt.setDeadline(lookupTimeout)
+		}
+
+		lines = append(lines, eventsToLines(resp.Events)...)
 
 		if resp.NextToken == nil || *resp.NextToken == "" {
 			break
@@ -231,7 +760,7 @@ func parseLookupAttribute(svc cloudtrailiface.CloudTrailAPI, attr *cloudtrail.Lo
 		params.NextToken = resp.NextToken
 	}
 
-	return nil
+	return lines, nil
 }
 
 // OutputWithEvent holds all data associated with a resource when the
@@ -249,14 +778,17 @@ type Output struct {
 	Tags            map[string]string
 }
 
-func printEvents(events []*cloudtrail.Event) {
+func eventsToLines(events []*cloudtrail.Event) []string {
+	var lines []string
 	for _, e := range events {
 		parsedEvent := gjson.Parse(*e.CloudTrailEvent)
-		printEvent(e, parsedEvent)
+		lines = append(lines, eventLines(e, parsedEvent)...)
 	}
+	return lines
 }
 
-func printEvent(event *cloudtrail.Event, parsedEvent gjson.Result) {
+func eventLines(event *cloudtrail.Event, parsedEvent gjson.Result) []string {
+	var lines []string
 	for _, r := range event.Resources {
 		nameStr, typeStr := aws.StringValue(r.ResourceName), aws.StringValue(r.ResourceType)
 
@@ -265,16 +797,20 @@ func printEvent(event *cloudtrail.Event, parsedEvent gjson.Result) {
 		}
 
 		rt, rn := arn.ResourceType(typeStr), arn.ResourceName(nameStr)
-		tmString := parseDataFromEvent(rt, rn, parsedEvent, event)
+		tmString := parseDataFromEvent(rt, rn, parsedEvent, event, "")
 		if tmString != "" {
-			fmt.Println(tmString)
+			lines = append(lines, tmString)
 		}
 	}
+	return lines
 }
 
-func parseDataFromEvent(rt arn.ResourceType, rn arn.ResourceName, parsedEvent gjson.Result, event *cloudtrail.Event) string {
+func parseDataFromEvent(rt arn.ResourceType, rn arn.ResourceName, parsedEvent gjson.Result, event *cloudtrail.Event, fallback arn.ResourceARN) string {
 	includeEvent := viper.GetBool("includeEvent")
 	ARN := arn.MapResourceTypeToARN(rt, rn, parsedEvent)
+	if ARN == "" {
+		ARN = fallback
+	}
 	if ARN == "" {
 		return ""
 	}
@@ -0,0 +1,382 @@
+// Copyright © 2017 grafiti/predator authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/tidwall/gjson"
+)
+
+// registryFixtures holds one recorded (trimmed) CloudTrail event per
+// registered descriptor and the resource name(s) it should resolve to,
+// covering both single- and multi-resource events. It's meant to cover every
+// eventName seeded by init(), so a typo'd ResourceNamePath or a ResourceType
+// arn.MapResourceTypeToARN doesn't map is caught instead of silently
+// dropping the event.
+var registryFixtures = []struct {
+	eventName string
+	raw       string
+	want      []string
+}{
+	{
+		"RunInstances",
+		`{"eventName":"RunInstances","responseElements":{"instancesSet":{"items":[{"instanceId":"i-111"},{"instanceId":"i-222"}]}}}`,
+		[]string{"i-111", "i-222"},
+	},
+	{
+		"CreateVpc",
+		`{"eventName":"CreateVpc","responseElements":{"vpc":{"vpcId":"vpc-123"}}}`,
+		[]string{"vpc-123"},
+	},
+	{
+		"CreateSubnet",
+		`{"eventName":"CreateSubnet","responseElements":{"subnet":{"subnetId":"subnet-123"}}}`,
+		[]string{"subnet-123"},
+	},
+	{
+		"CreateInternetGateway",
+		`{"eventName":"CreateInternetGateway","responseElements":{"internetGateway":{"internetGatewayId":"igw-123"}}}`,
+		[]string{"igw-123"},
+	},
+	{
+		"CreateSecurityGroup",
+		`{"eventName":"CreateSecurityGroup","responseElements":{"groupId":"sg-123"}}`,
+		[]string{"sg-123"},
+	},
+	{
+		"CreateNetworkInterface",
+		`{"eventName":"CreateNetworkInterface","responseElements":{"networkInterface":{"networkInterfaceId":"eni-123"}}}`,
+		[]string{"eni-123"},
+	},
+	{
+		"CreateVolume",
+		`{"eventName":"CreateVolume","responseElements":{"volumeId":"vol-123"}}`,
+		[]string{"vol-123"},
+	},
+	{
+		"CreateSnapshot",
+		`{"eventName":"CreateSnapshot","responseElements":{"snapshotId":"snap-123"}}`,
+		[]string{"snap-123"},
+	},
+	{
+		"CreateImage",
+		`{"eventName":"CreateImage","responseElements":{"imageId":"ami-123"}}`,
+		[]string{"ami-123"},
+	},
+	{
+		"CreateKeyPair",
+		`{"eventName":"CreateKeyPair","responseElements":{"keyName":"my-key"}}`,
+		[]string{"my-key"},
+	},
+	{
+		"AllocateAddress",
+		`{"eventName":"AllocateAddress","responseElements":{"allocationId":"eipalloc-123"}}`,
+		[]string{"eipalloc-123"},
+	},
+	{
+		"CreateRouteTable",
+		`{"eventName":"CreateRouteTable","responseElements":{"routeTable":{"routeTableId":"rtb-123"}}}`,
+		[]string{"rtb-123"},
+	},
+	{
+		"CreateNetworkAcl",
+		`{"eventName":"CreateNetworkAcl","responseElements":{"networkAcl":{"networkAclId":"acl-123"}}}`,
+		[]string{"acl-123"},
+	},
+	{
+		"CreateCustomerGateway",
+		`{"eventName":"CreateCustomerGateway","responseElements":{"customerGateway":{"customerGatewayId":"cgw-123"}}}`,
+		[]string{"cgw-123"},
+	},
+	{
+		"CreateVpnGateway",
+		`{"eventName":"CreateVpnGateway","responseElements":{"vpnGateway":{"vpnGatewayId":"vgw-123"}}}`,
+		[]string{"vgw-123"},
+	},
+	{
+		"CreateVpnConnection",
+		`{"eventName":"CreateVpnConnection","responseElements":{"vpnConnection":{"vpnConnectionId":"vpn-123"}}}`,
+		[]string{"vpn-123"},
+	},
+	{
+		"CreateVpcPeeringConnection",
+		`{"eventName":"CreateVpcPeeringConnection","responseElements":{"vpcPeeringConnection":{"vpcPeeringConnectionId":"pcx-123"}}}`,
+		[]string{"pcx-123"},
+	},
+	{
+		"CreateNatGateway",
+		`{"eventName":"CreateNatGateway","responseElements":{"natGateway":{"natGatewayId":"nat-123"}}}`,
+		[]string{"nat-123"},
+	},
+	{
+		"CreateVpcEndpoint",
+		`{"eventName":"CreateVpcEndpoint","responseElements":{"vpcEndpoint":{"vpcEndpointId":"vpce-123"}}}`,
+		[]string{"vpce-123"},
+	},
+	{
+		"CreateBucket",
+		`{"eventName":"CreateBucket","requestParameters":{"bucketName":"my-bucket"}}`,
+		[]string{"my-bucket"},
+	},
+	{
+		"CreateAutoScalingGroup",
+		`{"eventName":"CreateAutoScalingGroup","requestParameters":{"autoScalingGroupName":"my-asg"}}`,
+		[]string{"my-asg"},
+	},
+	{
+		"CreateLaunchConfiguration",
+		`{"eventName":"CreateLaunchConfiguration","requestParameters":{"launchConfigurationName":"my-lc"}}`,
+		[]string{"my-lc"},
+	},
+	{
+		"CreateLoadBalancer",
+		`{"eventName":"CreateLoadBalancer","requestParameters":{"loadBalancerName":"my-elb"}}`,
+		[]string{"my-elb"},
+	},
+	{
+		"CreateTargetGroup",
+		`{"eventName":"CreateTargetGroup","requestParameters":{"name":"my-tg"}}`,
+		[]string{"my-tg"},
+	},
+	{
+		"CreateRole",
+		`{"eventName":"CreateRole","requestParameters":{"roleName":"my-role"}}`,
+		[]string{"my-role"},
+	},
+	{
+		"CreateUser",
+		`{"eventName":"CreateUser","requestParameters":{"userName":"my-user"}}`,
+		[]string{"my-user"},
+	},
+	{
+		"CreateGroup",
+		`{"eventName":"CreateGroup","requestParameters":{"groupName":"my-group"}}`,
+		[]string{"my-group"},
+	},
+	{
+		"CreatePolicy",
+		`{"eventName":"CreatePolicy","responseElements":{"policy":{"policyName":"my-policy"}}}`,
+		[]string{"my-policy"},
+	},
+	{
+		"CreateInstanceProfile",
+		`{"eventName":"CreateInstanceProfile","requestParameters":{"instanceProfileName":"my-instance-profile"}}`,
+		[]string{"my-instance-profile"},
+	},
+	{
+		"CreateDBInstance",
+		`{"eventName":"CreateDBInstance","requestParameters":{"dBInstanceIdentifier":"my-db"}}`,
+		[]string{"my-db"},
+	},
+	{
+		"CreateDBSubnetGroup",
+		`{"eventName":"CreateDBSubnetGroup","requestParameters":{"dBSubnetGroupName":"my-db-subnet-group"}}`,
+		[]string{"my-db-subnet-group"},
+	},
+	{
+		"CreateDBSnapshot",
+		`{"eventName":"CreateDBSnapshot","requestParameters":{"dBSnapshotIdentifier":"my-db-snapshot"}}`,
+		[]string{"my-db-snapshot"},
+	},
+	{
+		"CreateHostedZone",
+		`{"eventName":"CreateHostedZone","responseElements":{"hostedZone":{"id":"/hostedzone/Z123"}}}`,
+		[]string{"/hostedzone/Z123"},
+	},
+	{
+		"CreateStack",
+		`{"eventName":"CreateStack","requestParameters":{"stackName":"my-stack"}}`,
+		[]string{"my-stack"},
+	},
+	{
+		"CreateQueue",
+		`{"eventName":"CreateQueue","requestParameters":{"queueName":"my-queue"}}`,
+		[]string{"my-queue"},
+	},
+	{
+		"CreateTopic",
+		`{"eventName":"CreateTopic","requestParameters":{"name":"my-topic"}}`,
+		[]string{"my-topic"},
+	},
+	{
+		"CreateTable",
+		`{"eventName":"CreateTable","requestParameters":{"tableName":"my-table"}}`,
+		[]string{"my-table"},
+	},
+	{
+		"CreateCacheCluster",
+		`{"eventName":"CreateCacheCluster","requestParameters":{"cacheClusterId":"my-cache-cluster"}}`,
+		[]string{"my-cache-cluster"},
+	},
+}
+
+// resolveResourceNames mirrors the name-resolution half of
+// parseRawCloudTrailEvent: looking up the registered EventDescriptor and
+// reading its ResourceNamePath out of the parsed event. It exists so
+// TestRawEventMapDescriptorsResolveFixtures can check every registered
+// descriptor's ResourceNamePath in isolation, without the pass/fail of the
+// whole fixture set hinging on arn.MapResourceTypeToARN recognizing every
+// resource type.
+func resolveResourceNames(eventName, raw string) ([]string, bool) {
+	d, ok := rawEventMap[eventName]
+	if !ok {
+		return nil, false
+	}
+
+	names := gjson.Parse(raw).Get(d.ResourceNamePath)
+	if !names.IsArray() {
+		return []string{names.String()}, true
+	}
+
+	var out []string
+	for _, n := range names.Array() {
+		out = append(out, n.String())
+	}
+	return out, true
+}
+
+func TestRawEventMapDescriptorsResolveFixtures(t *testing.T) {
+	registered := make(map[string]bool, len(rawEventMap))
+	for eventName := range rawEventMap {
+		registered[eventName] = true
+	}
+
+	for _, f := range registryFixtures {
+		t.Run(f.eventName, func(t *testing.T) {
+			delete(registered, f.eventName)
+
+			got, ok := resolveResourceNames(f.eventName, f.raw)
+			if !ok {
+				t.Fatalf("eventName %q not registered", f.eventName)
+			}
+
+			if len(got) != len(f.want) {
+				t.Fatalf("resolveResourceNames(%q) = %v, want %v", f.eventName, got, f.want)
+			}
+			for i := range got {
+				if got[i] != f.want[i] {
+					t.Errorf("resolveResourceNames(%q)[%d] = %q, want %q", f.eventName, i, got[i], f.want[i])
+				}
+			}
+		})
+	}
+
+	for eventName := range registered {
+		t.Errorf("eventName %q is registered but has no fixture in registryFixtures", eventName)
+	}
+}
+
+// TestParseRawCloudTrailEventResolvesFixtures drives every fixture through
+// the real parseRawCloudTrailEvent, rather than a reimplementation, so the
+// array-vs-scalar branch in parseRawCloudTrailEvent and the
+// arn.MapResourceTypeToARN call inside parseDataFromEvent are both actually
+// exercised. Each want name is asserted to appear in the output lines rather
+// than unmarshaled into a TaggingMetadata shape, matching how
+// cloudtrail_pool_test.go checks parseFromCloudTrail's output.
+func TestParseRawCloudTrailEventResolvesFixtures(t *testing.T) {
+	for _, f := range registryFixtures {
+		t.Run(f.eventName, func(t *testing.T) {
+			lines := parseRawCloudTrailEvent(f.raw)
+			if len(lines) != len(f.want) {
+				t.Fatalf("parseRawCloudTrailEvent(%q) returned %d lines, want %d: %v", f.eventName, len(lines), len(f.want), lines)
+			}
+
+			for i, want := range f.want {
+				if lines[i] == "" {
+					t.Errorf("parseRawCloudTrailEvent(%q)[%d] is empty, want a line naming %q", f.eventName, i, want)
+					continue
+				}
+				if !strings.Contains(lines[i], want) {
+					t.Errorf("parseRawCloudTrailEvent(%q)[%d] = %q, want it to contain %q", f.eventName, i, lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterOverridesExistingDescriptor(t *testing.T) {
+	const eventName = "CreateBucket"
+	original := rawEventMap[eventName]
+	defer Register(eventName, original)
+
+	Register(eventName, EventDescriptor{ResourceType: "overridden", ResourceNamePath: "requestParameters.bucketName"})
+
+	if got := rawEventMap[eventName].ResourceType; got != "overridden" {
+		t.Errorf("rawEventMap[%q].ResourceType = %q, want %q", eventName, got, "overridden")
+	}
+}
+
+func TestLoadRawEventMappingsRegistersConfigEntries(t *testing.T) {
+	const eventName = "CreateWidget"
+	defer delete(rawEventMap, eventName)
+
+	viper.Set("rawEventMappings", []map[string]interface{}{
+		{
+			"eventName":        eventName,
+			"resourceType":     "widget",
+			"resourceNamePath": "requestParameters.widgetName",
+			"arnTemplate":      "arn:aws:widget:::widget/%s",
+			"idPaths":          []string{"requestParameters.widgetName"},
+		},
+	})
+	defer viper.Set("rawEventMappings", nil)
+
+	if err := loadRawEventMappings(); err != nil {
+		t.Fatalf("loadRawEventMappings: %s", err)
+	}
+
+	d, ok := rawEventMap[eventName]
+	if !ok {
+		t.Fatalf("rawEventMap[%q] not registered from config", eventName)
+	}
+	if d.ResourceType != "widget" || d.ResourceNamePath != "requestParameters.widgetName" {
+		t.Errorf("rawEventMap[%q] = %+v, want {widget requestParameters.widgetName ...}", eventName, d)
+	}
+	if d.ARNTemplate != "arn:aws:widget:::widget/%s" || len(d.IDPaths) != 1 || d.IDPaths[0] != "requestParameters.widgetName" {
+		t.Errorf("rawEventMap[%q] ARNTemplate/IDPaths = %q/%v, want fallback ARN fields carried over from config", eventName, d.ARNTemplate, d.IDPaths)
+	}
+}
+
+func TestLoadRawEventMappingsFallbackARNResolvesUnmappedResourceType(t *testing.T) {
+	const eventName = "CreateWidget"
+	defer delete(rawEventMap, eventName)
+
+	viper.Set("rawEventMappings", []map[string]interface{}{
+		{
+			"eventName":        eventName,
+			"resourceType":     "widget",
+			"resourceNamePath": "requestParameters.widgetName",
+			"arnTemplate":      "arn:aws:widget:us-east-1:123456789012:widget/%s",
+			"idPaths":          []string{"requestParameters.widgetName"},
+		},
+	})
+	defer viper.Set("rawEventMappings", nil)
+
+	if err := loadRawEventMappings(); err != nil {
+		t.Fatalf("loadRawEventMappings: %s", err)
+	}
+
+	raw := `{"eventName":"CreateWidget","requestParameters":{"widgetName":"my-widget"}}`
+	lines := parseRawCloudTrailEvent(raw)
+	if len(lines) != 1 || lines[0] == "" {
+		t.Fatalf("parseRawCloudTrailEvent(%q) = %v, want one non-empty line using the fallback ARN", eventName, lines)
+	}
+	if !strings.Contains(lines[0], "arn:aws:widget:us-east-1:123456789012:widget/my-widget") {
+		t.Errorf("parseRawCloudTrailEvent(%q)[0] = %q, want it to contain the fallback ARN", eventName, lines[0])
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright © 2017 grafiti/predator authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+func TestParseS3IntervalEndpoint(t *testing.T) {
+	now := time.Date(2018, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"relative hours", "24h", now.Add(-24 * time.Hour), false},
+		{"relative days", "7d", now.Add(-7 * 24 * time.Hour), false},
+		{"rfc3339", "2018-01-01T00:00:00Z", time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"invalid", "not-a-duration", time.Time{}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseS3IntervalEndpoint(c.in, now)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("parseS3IntervalEndpoint(%q) expected error, got none", c.in)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseS3IntervalEndpoint(%q) unexpected error: %s", c.in, err)
+			}
+
+			if !got.Equal(c.want) {
+				t.Errorf("parseS3IntervalEndpoint(%q) = %s, want %s", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCalcTimeWindowFromS3Interval(t *testing.T) {
+	// single relative duration: start is in the past, end is "now".
+	start, end := calcTimeWindowFromS3Interval("24h")
+	if start == nil || end == nil {
+		t.Fatalf("calcTimeWindowFromS3Interval(\"24h\") returned nil window")
+	}
+	if !start.Before(*end) {
+		t.Errorf("start (%s) should be before end (%s)", start, end)
+	}
+
+	// explicit range.
+	start, end = calcTimeWindowFromS3Interval("2018-01-01T00:00:00Z,2018-01-02T00:00:00Z")
+	if start == nil || end == nil {
+		t.Fatalf("calcTimeWindowFromS3Interval(range) returned nil window")
+	}
+	wantStart := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	wantEnd := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	if !start.Equal(wantStart) || !end.Equal(wantEnd) {
+		t.Errorf("calcTimeWindowFromS3Interval(range) = (%s, %s), want (%s, %s)", start, end, wantStart, wantEnd)
+	}
+
+	// start at or after end is rejected.
+	if start, end := calcTimeWindowFromS3Interval("2018-01-02T00:00:00Z,2018-01-01T00:00:00Z"); start != nil || end != nil {
+		t.Errorf("calcTimeWindowFromS3Interval(backwards range) = (%v, %v), want (nil, nil)", start, end)
+	}
+}
+
+func TestTimeFromS3Key(t *testing.T) {
+	cases := []struct {
+		key  string
+		want time.Time
+		ok   bool
+	}{
+		{
+			"AWSLogs/123456789012/CloudTrail/us-east-1/2018/01/02/123456789012_CloudTrail_us-east-1_20180102T0305Z_abcdefgh.json.gz",
+			time.Date(2018, 1, 2, 3, 5, 0, 0, time.UTC),
+			true,
+		},
+		{"AWSLogs/123456789012/CloudTrail/not-a-log-key.json.gz", time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		got, ok := timeFromS3Key(c.key)
+		if ok != c.ok {
+			t.Fatalf("timeFromS3Key(%q) ok = %v, want %v", c.key, ok, c.ok)
+		}
+		if ok && !got.Equal(c.want) {
+			t.Errorf("timeFromS3Key(%q) = %s, want %s", c.key, got, c.want)
+		}
+	}
+}
+
+// fakeS3 is a minimal s3iface.S3API fake. Embedding the interface satisfies
+// every method grafiti doesn't exercise, and panics if one is called
+// unexpectedly.
+type fakeS3 struct {
+	s3iface.S3API
+
+	objects []*s3.Object
+	bodies  map[string][]byte
+	fetched []string
+}
+
+func (f *fakeS3) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	fn(&s3.ListObjectsV2Output{Contents: f.objects}, true)
+	return nil
+}
+
+func (f *fakeS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	key := aws.StringValue(in.Key)
+	f.fetched = append(f.fetched, key)
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(f.bodies[key]))}, nil
+}
+
+func gzipLogFile(t *testing.T, raw string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(raw)); err != nil {
+		t.Fatalf("gzip write: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("gzip close: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseFromS3BucketFiltersAndDecodesArchives(t *testing.T) {
+	inWindowKey := "AWSLogs/123456789012/CloudTrail/us-east-1/2018/01/02/123456789012_CloudTrail_us-east-1_20180102T0305Z_abcdefgh.json.gz"
+	outOfWindowKey := "AWSLogs/123456789012/CloudTrail/us-east-1/2018/01/05/123456789012_CloudTrail_us-east-1_20180105T0305Z_ijklmnop.json.gz"
+
+	logFile := `{"Records":[{"eventName":"CreateBucket","requestParameters":{"bucketName":"my-bucket"}}]}`
+
+	fake := &fakeS3{
+		objects: []*s3.Object{
+			{Key: aws.String(inWindowKey)},
+			{Key: aws.String(outOfWindowKey)},
+		},
+		bodies: map[string][]byte{
+			inWindowKey: gzipLogFile(t, logFile),
+		},
+	}
+
+	start := aws.Time(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	end := aws.Time(time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC))
+
+	if err := parseFromS3Bucket(fake, "my-bucket", "AWSLogs/123456789012/CloudTrail/us-east-1", start, end); err != nil {
+		t.Fatalf("parseFromS3Bucket: %s", err)
+	}
+
+	if len(fake.fetched) != 1 || fake.fetched[0] != inWindowKey {
+		t.Errorf("fetched = %v, want only %q", fake.fetched, inWindowKey)
+	}
+}